@@ -22,6 +22,7 @@ const epsilon = 1E-16 // 1E-16 is chosen because it is close to the ~52 bit limi
 // Histogram is a log-scaled histogram. It holds the accumulated counts
 type Histogram struct {
 	shift, scale float64 // precalculated values
+	low, high    float64 // the configured bounds, kept around for clamping and reporting
 
 	n                        uint64   // total # of accumulated samples in counts[], including outliers at counts[0] and counts[N+1]
 	counts                   []uint64 // buckets of counts + a low and high outlier bucket at [0] and [N+1]
@@ -51,6 +52,35 @@ func (h *Histogram) bucketToValue(bucket int) float64 {
 	return v
 }
 
+// interpolate returns a linearly-interpolated estimate of the value at rank pn, given that pn falls in
+// bucket ib, after before samples had already accumulated strictly below ib and after samples have
+// accumulated through and including ib. The two outlier buckets, and the low/high edges of the two
+// outermost real buckets, are clamped to the configured low/high rather than interpolated into the
+// (effectively unbounded) outlier range.
+func (h *Histogram) interpolate(ib int, before, after, pn uint64) float64 {
+	if ib <= 0 {
+		return h.low
+	}
+	if ib >= len(h.counts)-1 {
+		return h.high
+	}
+
+	lo := h.bucketToValue(ib - 1)
+	hi := h.bucketToValue(ib)
+	if ib == 1 {
+		lo = h.low
+	}
+	if ib == len(h.counts)-2 {
+		hi = h.high
+	}
+
+	count := after - before
+	if count == 0 {
+		return lo
+	}
+	return lo + float64(pn-before)/float64(count)*(hi-lo)
+}
+
 // New constructs a histogram to hold values between low and high using the given number of buckets
 func New(low, high float64, num_buckets int) *Histogram {
 	h := &Histogram{}
@@ -73,6 +103,8 @@ func (h *Histogram) init(low, high float64, num_buckets int) {
 	h.counts = make([]uint64, 2+num_buckets)
 	h.shift = shift
 	h.scale = scale
+	h.low = low
+	h.high = high
 	h.middle_bucket_percentile = -1
 }
 
@@ -99,93 +131,56 @@ func (h *Histogram) raceyAccumulate(x float64) {
 	h.n++
 }
 
-// Count returns the total number of samples accumulated, including outliers
-func (h *Histogram) Count() uint64 { return atomic.LoadUint64(&h.n) }
+// Count returns the total number of samples accumulated, including outliers.
+//
+// Count takes a Snapshot internally (see Snapshot), so callers making several queries against the same
+// data (e.g. Count plus a handful of Percentiles) should take one Snapshot themselves and query that
+// instead of calling these thin per-query wrappers repeatedly.
+func (h *Histogram) Count() uint64 { return h.Snapshot().Count() }
 
 // Outliers returns the number of outliers on either side (how may samples were outside the low...high bound)
-func (h *Histogram) Outliers() (uint64, uint64) {
-	return atomic.LoadUint64(&h.counts[0]), atomic.LoadUint64(&h.counts[len(h.counts)-1])
-}
+func (h *Histogram) Outliers() (uint64, uint64) { return h.Snapshot().Outliers() }
 
-// Percentiles returns the values at each percentile. NaN is returned if Count is 0 or percentiles are outside the 0...100 range.
-// pers argument MUST be sorted low-to-high. NOTE outliers are taken into account as best we can, so the results can be outside
-// of low...high if the percentile requested lies within the outliers.
-func (h *Histogram) Percentiles(pers ...float64) []float64 {
-	// check for stupid args
-	if len(pers) == 0 {
-		return nil
-	}
-
-	values := make([]float64, len(pers))
-
-	// if the data values are evenly spread then scalling for percentiles starting from the highest
-	// values to lower ones would be faster (since the high buckets are larger and would have more
-	// of the total for fewer buckets scanned). But if you're using this log-scaled histogram rather
-	// than a linear histogram it's probably because the distribution of values is skewed. In a common
-	// use case of latency measurements, it's often very very skewed, with only a few outliers at the
-	// top of the scale. Scanning for the 90% or 99% percentiles (often those of interest) can be
-	// more efficient from below as from above, depending on the distribution.
-	// A first good guess is to do it from below, but keeping track of the percentile of the middle
-	// bucket lets us guess properly next time.
-
-	if h.middle_bucket_percentile >= 0 && pers[0] > h.middle_bucket_percentile {
-		// find the percentiles from high to low. this can be more efficient when asking for things like the 99% percentile
-		// because we only need to scan over 1% of the counts.
-		// (the log-sized buckets can make the outliers efficient, even if there aren't a lot of them)
-		n := atomic.LoadUint64(&h.n)
-		a := n
-		if n == 0 {
-			goto return_nans
-		}
-		nf := float64(n)
-		i := len(h.counts) - 1
-		for j := len(pers) - 1; j >= 0; j-- {
-			p := pers[j]
-			pn := uint64(p * nf / 100)
-			for a >= pn && i >= 0 {
-				a -= atomic.LoadUint64(&h.counts[i])
-				i--
-			}
-			values[j] = h.bucketToValue(i)
-		}
-	} else {
-		// find the percentiles from low to high
-		a := uint64(0)
-		n := atomic.LoadUint64(&h.n)
-		if n == 0 {
-			goto return_nans
-		}
-		nf := float64(n)
-		i := 0
-		middle_bucket := len(h.counts) / 2
-		for j, p := range pers {
-			pn := uint64(p * nf / 100)
-			for a < pn && i < len(h.counts) {
-				a += atomic.LoadUint64(&h.counts[i])
-				if i == middle_bucket {
-					// update our estimate of the middle bucket's percentile
-					h.middle_bucket_percentile = 100 * float64(a) / float64(n)
-				}
-				i++
-			}
-			values[j] = h.bucketToValue(i)
-		}
-	}
+// Percentiles returns the values at each percentile, linearly interpolated between the bounds of the
+// bucket the percentile's rank falls in (rather than just returning that bucket's lower bound), which
+// materially improves accuracy for high percentiles given only 1000 log-spaced buckets. NaN is returned
+// if Count is 0 or percentiles are outside the 0...100 range. pers argument MUST be sorted low-to-high.
+// NOTE outliers are counted in the ranks, and a percentile that lies within the low or high outliers is
+// reported as low or high respectively.
+func (h *Histogram) Percentiles(pers ...float64) []float64 { return h.Snapshot().Percentiles(pers...) }
 
-	return values
+// Percentile calculates one percentile
+func (h *Histogram) Percentile(per float64) float64 { return h.Snapshot().Percentile(per) }
 
-return_nans:
-	nan := math.NaN()
-	for i := range values {
-		values[i] = nan
-	}
-	return values
-}
+// Sum returns the approximate sum of all accumulated samples (outliers excluded, since their true value
+// isn't known), computed from the bucket counts using each bucket's midpoint as a stand-in for the
+// values that landed in it. NaN is returned if Count is 0.
+func (h *Histogram) Sum() float64 { return h.Snapshot().Sum() }
 
-// Percentile calculates one percentile
-func (h *Histogram) Percentile(per float64) float64 {
-	return h.Percentiles(per)[0]
-}
+// Mean returns the approximate mean of all accumulated samples (outliers excluded). NaN is returned if
+// Count is 0.
+func (h *Histogram) Mean() float64 { return h.Snapshot().Mean() }
+
+// Variance returns the approximate variance of all accumulated samples (outliers excluded), computed
+// with the standard one-pass sum/sum-of-squares approach over bucket midpoints. NaN is returned if
+// Count is 0.
+func (h *Histogram) Variance() float64 { return h.Snapshot().Variance() }
+
+// StdDev returns the approximate standard deviation of all accumulated samples. NaN is returned if
+// Count is 0.
+func (h *Histogram) StdDev() float64 { return h.Snapshot().StdDev() }
+
+// Min returns the lower bound of the lowest non-empty, non-outlier bucket. NaN is returned if Count is
+// 0, or if every accumulated sample is an outlier.
+func (h *Histogram) Min() float64 { return h.Snapshot().Min() }
+
+// Max returns the upper bound of the highest non-empty, non-outlier bucket. NaN is returned if Count is
+// 0, or if every accumulated sample is an outlier.
+func (h *Histogram) Max() float64 { return h.Snapshot().Max() }
+
+// Buckets returns the per-bucket counts and the value range each one covers, including the two outlier
+// buckets. See Snapshot.Buckets.
+func (h *Histogram) Buckets() []Bucket { return h.Snapshot().Buckets() }
 
 // Dup returns a copy of h
 func (h *Histogram) Dup() *Histogram {
@@ -239,3 +234,75 @@ func Sub(h1, h2 *Histogram) *Histogram {
 
 	return &h
 }
+
+// Add adds h2 into h in-place. h += h2. h and h2 must be the same size, so that bucket i means the same
+// thing in both. This is the counterpart to Sub, used to fan-in per-goroutine or per-shard histograms
+// into one for reporting.
+func (h *Histogram) Add(h2 *Histogram) {
+	if len(h.counts) != len(h2.counts) {
+		panic("adding different-sized histograms")
+	}
+	// I could also check the low and high, but that's sometimes useful, so don't
+
+	for i := range h2.counts {
+		c := atomic.LoadUint64(&h2.counts[i])
+		atomic.AddUint64(&h.counts[i], c)
+		atomic.AddUint64(&h.n, c) // keep the 'n' as up-to-date as Accumulate does, rather than adjust n once at the end of the loop
+	}
+}
+
+// Add returns h1+h2 without changing h1 nor h2. h1 and h2 must be the same size; use AddWithBounds if
+// they were constructed with different (low, high, num_buckets).
+func Add(h1, h2 *Histogram) *Histogram {
+	if len(h1.counts) != len(h2.counts) {
+		panic("adding different-sized histograms")
+	}
+	// I could also check the low and high, but that's sometimes useful, so don't
+
+	h := *h1
+	h.counts = make([]uint64, len(h1.counts))
+	n := uint64(0)
+	for i := range h1.counts {
+		c1 := atomic.LoadUint64(&h1.counts[i])
+		c2 := atomic.LoadUint64(&h2.counts[i])
+		h.counts[i] = c1 + c2
+		n += c1 + c2
+	}
+	h.n = n
+
+	return &h
+}
+
+// AddWithBounds adds h2 into h in-place like Add, but tolerates h2 having a different (low, high,
+// num_buckets) than h. Each of h2's buckets is resampled into h's layout by mapping the bucket's
+// midpoint back through h.valueToBucket, so worker sub-histograms built with different constructor
+// arguments can still be fanned in to one reporting histogram. Outliers stay outliers on both ends.
+// This is necessarily lossier than Add, since a source bucket's count is all attributed to whichever
+// single destination bucket its midpoint falls in.
+func (h *Histogram) AddWithBounds(h2 *Histogram) {
+	if h.shift == h2.shift && h.scale == h2.scale && len(h.counts) == len(h2.counts) {
+		h.Add(h2)
+		return
+	}
+
+	for i := range h2.counts {
+		c := atomic.LoadUint64(&h2.counts[i])
+		if c == 0 {
+			continue
+		}
+
+		var j int
+		switch i {
+		case 0:
+			j = 0 // low outlier maps to low outlier
+		case len(h2.counts) - 1:
+			j = len(h.counts) - 1 // high outlier maps to high outlier
+		default:
+			mid := (h2.bucketToValue(i-1) + h2.bucketToValue(i)) / 2
+			j = h.valueToBucket(mid)
+		}
+
+		atomic.AddUint64(&h.counts[j], c)
+		atomic.AddUint64(&h.n, c)
+	}
+}
@@ -44,6 +44,54 @@ func TestOutliers(t *testing.T) {
 	}
 }
 
+func TestAddWithBounds(t *testing.T) {
+	h := New(0, 1000, 10)
+	h2 := New(0, 1000, 20)
+	for i := 0; i < 5; i++ {
+		h2.Accumulate(300)
+	}
+
+	h.AddWithBounds(h2)
+
+	if h.Count() != 5 {
+		t.Fatalf("h.Count() = %v, want 5", h.Count())
+	}
+	// the resampled count must land in the same bucket h.Accumulate(300) would have landed in
+	// directly, not the bucket to either side of it
+	j := h.valueToBucket(300)
+	if h.counts[j] != 5 {
+		t.Errorf("resampled count landed in bucket %v (count %v), want it in bucket %v", j, h.counts[j], j)
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	h := New(0, 1000, 1000)
+	for i := 0; i < 10; i++ {
+		h.Accumulate(300)
+	}
+
+	if sum := h.Sum(); math.Abs(sum-3000) > 30 {
+		t.Errorf("h.Sum() = %v, want ~3000", sum)
+	}
+	if mean := h.Mean(); math.Abs(mean-300) > 3 {
+		t.Errorf("h.Mean() = %v, want ~300", mean)
+	}
+	if lo, hi := h.Min(), h.Max(); !(lo <= 300 && 300 <= hi) {
+		t.Errorf("h.Min(), h.Max() = %v, %v; want a range containing 300", lo, hi)
+	}
+}
+
+func TestPercentileInterpolation(t *testing.T) {
+	h := New(0, 1000, 1000)
+	for i := 0; i < 1000; i++ {
+		h.Accumulate(float64(i))
+	}
+
+	if p := h.Percentile(50); math.Abs(p-500) > 10 {
+		t.Errorf("h.Percentile(50) = %v, want ~500", p)
+	}
+}
+
 func TestEmptyHistogram(t *testing.T) {
 	h := New(0, 1, 10)
 	p := h.Percentile(50)
@@ -0,0 +1,107 @@
+/*
+  prom adapts a loghistogram.Histogram or loghistogram.WindowedHistogram to prometheus.Collector and
+  expvar.Var, so existing Prometheus/expvar scraping and tooling works against this package's
+  histograms, at far lower Observe/Accumulate cost than prometheus.Histogram.
+
+  Copyright 2017 Nicolas Dade
+*/
+
+package prom
+
+import (
+	"math"
+	"time"
+
+	"github.com/nsd20463/loghistogram"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Source is whatever a Collector or Var adapts. Both *loghistogram.Histogram and
+// *loghistogram.WindowedHistogram satisfy it already.
+type Source interface {
+	Count() uint64
+	Sum() float64
+	Buckets() []loghistogram.Bucket
+}
+
+// Collector adapts a Source to prometheus.Collector, exposing it as a real Prometheus histogram metric
+// (NewConstHistogram, with <name>_bucket/_count/_sum of CounterValue type, not four independent
+// Gauges), plus a <name>_created gauge. The log-spaced bucket boundaries map naturally onto the "le"
+// label of the exported histogram, using each bucket's upper bound as its le. The high outlier bucket
+// folds into the +Inf bucket, since Prometheus always wants one; there's no equivalent -Inf bucket, so
+// the low outlier is counted in every bucket's cumulative count (keeping +Inf equal to _count) and is
+// also reported on its own as <name>_low_outliers_total.
+type Collector struct {
+	source Source
+
+	histDesc       *prometheus.Desc
+	createdDesc    *prometheus.Desc
+	lowOutlierDesc *prometheus.Desc
+
+	labelValues []string // fixed at construction, in the same order as the label names baked into the *Descs above
+	created     float64  // unix seconds this Collector was created
+}
+
+// NewCollector adapts source (a *loghistogram.Histogram or *loghistogram.WindowedHistogram) to a
+// prometheus.Collector reporting as name, with the given constant labels attached to every series it
+// emits -- e.g. one Collector per endpoint, labeled with that endpoint's name, so a single scrape can
+// emit per-endpoint latency histograms. Register the result with a prometheus.Registry the way you
+// would any other Collector.
+func NewCollector(name, help string, labels prometheus.Labels, source Source) *Collector {
+	labelNames := make([]string, 0, len(labels))
+	labelValues := make([]string, 0, len(labels))
+	for k, v := range labels {
+		labelNames = append(labelNames, k)
+		labelValues = append(labelValues, v)
+	}
+
+	return &Collector{
+		source:      source,
+		labelValues: labelValues,
+		created:     float64(time.Now().Unix()),
+
+		histDesc:       prometheus.NewDesc(name, help, labelNames, nil),
+		createdDesc:    prometheus.NewDesc(name+"_created", help+" (unix time this series was created)", labelNames, nil),
+		lowOutlierDesc: prometheus.NewDesc(name+"_low_outliers_total", help+" (samples below the histogram's configured low bound)", labelNames, nil),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.histDesc
+	ch <- c.createdDesc
+	ch <- c.lowOutlierDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	// Buckets, Count and Sum are read as close together as the Source allows: a plain
+	// *loghistogram.Histogram snapshots once up front so all three come off one consistent view; a
+	// *loghistogram.WindowedHistogram has no equivalent combined snapshot, so its scrape is only as
+	// consistent as each individual call already is.
+	var buckets []loghistogram.Bucket
+	var count uint64
+	var sum float64
+	if h, ok := c.source.(*loghistogram.Histogram); ok {
+		s := h.Snapshot()
+		buckets, count, sum = s.Buckets(), s.Count(), s.Sum()
+	} else {
+		buckets, count, sum = c.source.Buckets(), c.source.Count(), c.source.Sum()
+	}
+
+	// Prometheus histograms have no notion of a low outlier: every observation is assumed to fall
+	// somewhere at or below +Inf, so sample_count must equal the +Inf bucket's cumulative count. The
+	// low outliers are folded into every bucket's running total here (same as the implicit -Inf..first
+	// bucket of a normal prometheus.Histogram) to preserve that invariant, in addition to being
+	// reported on their own via lowOutlierDesc below.
+	cumulative := make(map[float64]uint64, len(buckets)-1)
+	running := buckets[0].Count
+	for _, b := range buckets[1 : len(buckets)-1] {
+		running += b.Count
+		cumulative[b.High] = running
+	}
+	running += buckets[len(buckets)-1].Count // the high outlier folds into the +Inf bucket
+	cumulative[math.Inf(1)] = running
+
+	ch <- prometheus.MustNewConstHistogram(c.histDesc, count, sum, cumulative, c.labelValues...)
+	ch <- prometheus.MustNewConstMetric(c.createdDesc, prometheus.GaugeValue, c.created, c.labelValues...)
+	ch <- prometheus.MustNewConstMetric(c.lowOutlierDesc, prometheus.CounterValue, float64(buckets[0].Count), c.labelValues...)
+}
@@ -0,0 +1,64 @@
+package prom
+
+import (
+	"testing"
+
+	"github.com/nsd20463/loghistogram"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollectorExportsHistogramType(t *testing.T) {
+	h := loghistogram.New(0, 100, 10)
+	for i := 0; i < 5; i++ {
+		h.Accumulate(1)
+	}
+	h.Accumulate(-1)  // low outlier
+	h.Accumulate(200) // high outlier
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewCollector("test_hist", "a test histogram", nil, h))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, f := range families {
+		byName[f.GetName()] = f
+	}
+
+	hf, ok := byName["test_hist"]
+	if !ok {
+		t.Fatal("test_hist family not exported")
+	}
+	if hf.GetType() != dto.MetricType_HISTOGRAM {
+		t.Errorf("test_hist type = %v, want HISTOGRAM -- the whole point of this request is that it's\n"+
+			"a real histogram, not GaugeValue-typed _bucket/_count/_sum series", hf.GetType())
+	}
+	m := hf.GetMetric()[0].GetHistogram()
+	if m.GetSampleCount() != 7 {
+		t.Errorf("SampleCount = %v, want 7 (5 real samples + 2 outliers)", m.GetSampleCount())
+	}
+	// Prometheus histograms have no notion of a low outlier: every sample must be accounted for by
+	// +Inf's cumulative count, or downstream histogram_quantile/rate math silently breaks.
+	buckets := m.GetBucket()
+	if got := buckets[len(buckets)-1].GetCumulativeCount(); got != m.GetSampleCount() {
+		t.Errorf("+Inf bucket cumulative count = %v, want it to equal SampleCount (%v)", got, m.GetSampleCount())
+	}
+
+	if _, ok := byName["test_hist_created"]; !ok {
+		t.Error("test_hist_created family not exported")
+	}
+	lof, ok := byName["test_hist_low_outliers_total"]
+	if !ok {
+		t.Fatal("test_hist_low_outliers_total family not exported")
+	}
+	if lof.GetType() != dto.MetricType_COUNTER {
+		t.Errorf("test_hist_low_outliers_total type = %v, want COUNTER", lof.GetType())
+	}
+	if got := lof.GetMetric()[0].GetCounter().GetValue(); got != 1 {
+		t.Errorf("test_hist_low_outliers_total = %v, want 1", got)
+	}
+}
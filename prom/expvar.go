@@ -0,0 +1,31 @@
+package prom
+
+import (
+	"encoding/json"
+	"expvar"
+)
+
+// Var adapts a Source to expvar.Var, so it can be published with expvar.Publish and read back from
+// /debug/vars, rendering as a small JSON object with the same count/sum a Collector exposes. An empty
+// source's Sum is NaN, which JSON can't encode, so it renders as just "{}".
+type Var struct {
+	source Source
+}
+
+var _ expvar.Var = (*Var)(nil)
+
+// NewVar adapts source (a *loghistogram.Histogram or *loghistogram.WindowedHistogram) to expvar.Var.
+func NewVar(source Source) *Var {
+	return &Var{source: source}
+}
+
+func (v *Var) String() string {
+	b, err := json.Marshal(struct {
+		Count uint64  `json:"count"`
+		Sum   float64 `json:"sum"`
+	}{v.source.Count(), v.source.Sum()})
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
@@ -0,0 +1,145 @@
+/*
+  binary encoding of a Histogram, for shipping between processes or snapshotting to disk.
+
+  Copyright 2017 Nicolas Dade
+*/
+
+package loghistogram
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+)
+
+// histogramFormatVersion is the version byte at the start of every encoding produced by MarshalBinary.
+// Bump it if the wire format ever changes incompatibly.
+const histogramFormatVersion = 1
+
+// MarshalBinary encodes h into a compact, self-describing binary format suitable for shipping between
+// processes or snapshotting to disk. The header records a version byte plus the low, high and
+// num_buckets h was constructed with, and n. Real histograms are usually mostly-empty (log-scaled
+// buckets with a skewed distribution), so the bucket counts -- including the two outlier buckets -- are
+// encoded as a run-length + varint scheme: one (zero-run-length, count) varint pair per non-zero bucket,
+// stopping after the last non-zero bucket. A mostly-empty 1000-bucket histogram therefore serializes in
+// tens of bytes rather than the 8KB a dense encoding would need.
+func (h *Histogram) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+
+	var hdr [1 + 8 + 8]byte
+	hdr[0] = histogramFormatVersion
+	binary.BigEndian.PutUint64(hdr[1:9], math.Float64bits(h.low))
+	binary.BigEndian.PutUint64(hdr[9:17], math.Float64bits(h.high))
+	buf.Write(hdr[:])
+
+	var v [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(v[:], uint64(len(h.counts)-2))
+	buf.Write(v[:n])
+	n = binary.PutUvarint(v[:], atomic.LoadUint64(&h.n))
+	buf.Write(v[:n])
+
+	lastNonZero := -1
+	for i := range h.counts {
+		if atomic.LoadUint64(&h.counts[i]) != 0 {
+			lastNonZero = i
+		}
+	}
+
+	zeroRun := uint64(0)
+	for i := 0; i <= lastNonZero; i++ {
+		c := atomic.LoadUint64(&h.counts[i])
+		if c == 0 {
+			zeroRun++
+			continue
+		}
+		n := binary.PutUvarint(v[:], zeroRun)
+		buf.Write(v[:n])
+		n = binary.PutUvarint(v[:], c)
+		buf.Write(v[:n])
+		zeroRun = 0
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a histogram previously encoded with MarshalBinary into h, replacing h's
+// counts. The decoded low, high and num_buckets must match h's; construct h with New using the values
+// you encoded with (or plumb AddWithBounds's resampling through) if they don't.
+func (h *Histogram) UnmarshalBinary(data []byte) error {
+	if len(data) < 17 || data[0] != histogramFormatVersion {
+		return fmt.Errorf("loghistogram: unrecognized or truncated encoding")
+	}
+	low := math.Float64frombits(binary.BigEndian.Uint64(data[1:9]))
+	high := math.Float64frombits(binary.BigEndian.Uint64(data[9:17]))
+	data = data[17:]
+
+	numBuckets, n := binary.Uvarint(data)
+	if n <= 0 {
+		return fmt.Errorf("loghistogram: truncated encoding")
+	}
+	data = data[n:]
+
+	total, n := binary.Uvarint(data)
+	if n <= 0 {
+		return fmt.Errorf("loghistogram: truncated encoding")
+	}
+	data = data[n:]
+
+	if low != h.low || high != h.high || int(numBuckets) != len(h.counts)-2 {
+		return fmt.Errorf("loghistogram: encoded histogram (%v, %v, %v buckets) doesn't match (%v, %v, %v buckets)",
+			low, high, numBuckets, h.low, h.high, len(h.counts)-2)
+	}
+
+	counts := make([]uint64, len(h.counts))
+	i := 0
+	for len(data) > 0 {
+		zeroRun, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("loghistogram: corrupt encoding")
+		}
+		data = data[n:]
+		i += int(zeroRun)
+		if i >= len(counts) {
+			return fmt.Errorf("loghistogram: corrupt encoding")
+		}
+
+		c, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("loghistogram: corrupt encoding")
+		}
+		data = data[n:]
+		counts[i] = c
+		i++
+	}
+
+	for i := range counts {
+		atomic.StoreUint64(&h.counts[i], counts[i])
+	}
+	atomic.StoreUint64(&h.n, total)
+
+	return nil
+}
+
+// WriteTo writes h's binary encoding (see MarshalBinary) to w, and returns the number of bytes written.
+func (h *Histogram) WriteTo(w io.Writer) (int64, error) {
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// ReadFrom reads a binary encoding (see MarshalBinary) from r into h, replacing h's counts, and returns
+// the number of bytes read.
+func (h *Histogram) ReadFrom(r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	n := int64(len(data))
+	if err != nil {
+		return n, err
+	}
+	return n, h.UnmarshalBinary(data)
+}
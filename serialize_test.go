@@ -0,0 +1,68 @@
+package loghistogram
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMarshalUnmarshalBinary(t *testing.T) {
+	h := New(0, 1000, 100)
+	for i := 0; i < 100; i++ {
+		h.Accumulate(float64(i * i))
+	}
+	h.Accumulate(-1)    // low outlier
+	h.Accumulate(10000) // high outlier
+
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	h2 := New(0, 1000, 100)
+	if err := h2.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+
+	if h2.Count() != h.Count() {
+		t.Errorf("h2.Count() = %v, want %v", h2.Count(), h.Count())
+	}
+	for i := range h.counts {
+		if h2.counts[i] != h.counts[i] {
+			t.Errorf("counts[%v] = %v, want %v", i, h2.counts[i], h.counts[i])
+		}
+	}
+}
+
+func TestUnmarshalBinaryMismatchedBounds(t *testing.T) {
+	h := New(0, 1000, 100)
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	h2 := New(0, 2000, 100)
+	if err := h2.UnmarshalBinary(data); err == nil {
+		t.Error("UnmarshalBinary into a histogram with different bounds should have failed")
+	}
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	h := New(0, 1000, 100)
+	for i := 0; i < 50; i++ {
+		h.Accumulate(float64(i * 10))
+	}
+
+	var buf bytes.Buffer
+	if _, err := h.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	h2 := New(0, 1000, 100)
+	if _, err := h2.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+
+	if h2.Count() != h.Count() {
+		t.Errorf("h2.Count() = %v, want %v", h2.Count(), h.Count())
+	}
+}
@@ -0,0 +1,249 @@
+/*
+  Snapshot is an immutable, point-in-time copy of a Histogram's bucket counts.
+
+  Copyright 2017 Nicolas Dade
+*/
+
+package loghistogram
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Snapshot is an immutable copy of a Histogram's bucket counts at some point in time. Scanning a live
+// Histogram's buckets with a fresh atomic.LoadUint64 per bucket while a writer is concurrently
+// Accumulate()ing can see counts drift mid-scan -- the rank sums it computes won't necessarily match n --
+// so Histogram's own read methods (Count, Percentiles, etc.) each take a Snapshot and query that
+// instead. Snapshot's counts and n are set
+// once at creation and never mutated, so reading them needs no atomics; the one exception is
+// middleBucketPercentileBits, a cache Percentiles updates as it scans, which is accessed with atomic
+// loads/stores precisely so that a reader that wants several consistent queries against the same data
+// (e.g. a /metrics scrape computing many percentiles and the statistical summaries) can take one
+// Snapshot and query it repeatedly, even from multiple goroutines, rather than re-scanning the live
+// Histogram for each query.
+type Snapshot struct {
+	shift, scale float64
+	low, high    float64
+
+	n      uint64
+	counts []uint64
+
+	// middleBucketPercentileBits is math.Float64bits of a cached guess, updated by Percentiles as it
+	// scans, of what percentile falls at the middle bucket (by index) -- or of -1 if no guess has been
+	// made yet. Percentiles uses it to decide whether to scan counts from the bottom or the top. Stored
+	// as bits and accessed with atomic.Load/StoreUint64 since, unlike the rest of Snapshot, it's mutated
+	// after construction and queries may run concurrently.
+	middleBucketPercentileBits uint64
+}
+
+// Snapshot takes an immutable, consistent copy of h's current counts. It is essentially Dup, but returns
+// a distinct read-only type whose methods don't need atomics for the bucket counts.
+func (h *Histogram) Snapshot() *Snapshot {
+	s := &Snapshot{
+		shift:                      h.shift,
+		scale:                      h.scale,
+		low:                        h.low,
+		high:                       h.high,
+		counts:                     make([]uint64, len(h.counts)),
+		middleBucketPercentileBits: math.Float64bits(-1),
+	}
+
+	n := uint64(0)
+	for i := range s.counts {
+		c := atomic.LoadUint64(&h.counts[i])
+		n += c
+		s.counts[i] = c
+	}
+	s.n = n
+
+	return s
+}
+
+func (s *Snapshot) bucketToValue(bucket int) float64 {
+	return math.Exp(float64(bucket)/s.scale) + s.shift
+}
+
+// interpolate mirrors Histogram.interpolate; see it for the rationale.
+func (s *Snapshot) interpolate(ib int, before, after, pn uint64) float64 {
+	if ib <= 0 {
+		return s.low
+	}
+	if ib >= len(s.counts)-1 {
+		return s.high
+	}
+
+	lo := s.bucketToValue(ib - 1)
+	hi := s.bucketToValue(ib)
+	if ib == 1 {
+		lo = s.low
+	}
+	if ib == len(s.counts)-2 {
+		hi = s.high
+	}
+
+	count := after - before
+	if count == 0 {
+		return lo
+	}
+	return lo + float64(pn-before)/float64(count)*(hi-lo)
+}
+
+// Count returns the total number of samples in the snapshot, including outliers.
+func (s *Snapshot) Count() uint64 { return s.n }
+
+// Outliers returns the number of outliers on either side (how many samples were outside the low...high
+// bound) in the snapshot.
+func (s *Snapshot) Outliers() (uint64, uint64) {
+	return s.counts[0], s.counts[len(s.counts)-1]
+}
+
+// Percentiles returns the values at each percentile, linearly interpolated within the bucket the
+// percentile's rank falls in. NaN is returned if Count is 0 or percentiles are outside the 0...100
+// range. pers argument MUST be sorted low-to-high. NOTE outliers are counted in the ranks, and a
+// percentile that lies within the low or high outliers is reported as low or high respectively.
+func (s *Snapshot) Percentiles(pers ...float64) []float64 {
+	if len(pers) == 0 {
+		return nil
+	}
+
+	values := make([]float64, len(pers))
+
+	if s.n == 0 {
+		nan := math.NaN()
+		for i := range values {
+			values[i] = nan
+		}
+		return values
+	}
+
+	nf := float64(s.n)
+	middleBucketPercentile := math.Float64frombits(atomic.LoadUint64(&s.middleBucketPercentileBits))
+	if middleBucketPercentile >= 0 && pers[0] > middleBucketPercentile {
+		a := s.n
+		i := len(s.counts) - 1
+		for j := len(pers) - 1; j >= 0; j-- {
+			p := pers[j]
+			if p < 0 || p > 100 {
+				values[j] = math.NaN()
+				continue
+			}
+			pn := uint64(p * nf / 100)
+			for a >= pn && i >= 0 {
+				a -= s.counts[i]
+				i--
+			}
+			ib := i + 1
+			before, after := a, a
+			if ib < len(s.counts) {
+				after = a + s.counts[ib]
+			}
+			values[j] = s.interpolate(ib, before, after, pn)
+		}
+	} else {
+		a := uint64(0)
+		i := 0
+		before := uint64(0)
+		middle_bucket := len(s.counts) / 2
+		for j, p := range pers {
+			if p < 0 || p > 100 {
+				values[j] = math.NaN()
+				continue
+			}
+			pn := uint64(p * nf / 100)
+			for a < pn && i < len(s.counts) {
+				before = a
+				a += s.counts[i]
+				if i == middle_bucket {
+					atomic.StoreUint64(&s.middleBucketPercentileBits, math.Float64bits(100*float64(a)/nf))
+				}
+				i++
+			}
+			ib := i - 1
+			if ib < 0 {
+				ib = 0
+			}
+			values[j] = s.interpolate(ib, before, a, pn)
+		}
+	}
+
+	return values
+}
+
+// Percentile calculates one percentile
+func (s *Snapshot) Percentile(per float64) float64 {
+	return s.Percentiles(per)[0]
+}
+
+// Sum returns the approximate sum of all samples in the snapshot (outliers excluded), computed from the
+// bucket counts using each bucket's midpoint as a stand-in for the values that landed in it. NaN is
+// returned if Count is 0.
+func (s *Snapshot) Sum() float64 {
+	if s.n == 0 {
+		return math.NaN()
+	}
+	return s.bucketStats().sum(s.counts)
+}
+
+// Mean returns the approximate mean of all samples in the snapshot (outliers excluded). NaN is returned
+// if Count is 0.
+func (s *Snapshot) Mean() float64 {
+	if s.n == 0 {
+		return math.NaN()
+	}
+	inRange := s.n - s.counts[0] - s.counts[len(s.counts)-1]
+	return s.Sum() / float64(inRange)
+}
+
+// Variance returns the approximate variance of all samples in the snapshot (outliers excluded),
+// computed with the standard one-pass sum/sum-of-squares approach over bucket midpoints. NaN is
+// returned if Count is 0.
+func (s *Snapshot) Variance() float64 {
+	if s.n == 0 {
+		return math.NaN()
+	}
+	return s.bucketStats().variance(s.counts, s.n)
+}
+
+// StdDev returns the approximate standard deviation of all samples in the snapshot. NaN is returned if
+// Count is 0.
+func (s *Snapshot) StdDev() float64 {
+	return math.Sqrt(s.Variance())
+}
+
+// Min returns the lower bound of the lowest non-empty, non-outlier bucket in the snapshot. NaN is
+// returned if Count is 0, or if every sample is an outlier.
+func (s *Snapshot) Min() float64 {
+	if s.n == 0 {
+		return math.NaN()
+	}
+	return s.bucketStats().min(len(s.counts), func(i int) uint64 { return s.counts[i] })
+}
+
+// Max returns the upper bound of the highest non-empty, non-outlier bucket in the snapshot. NaN is
+// returned if Count is 0, or if every sample is an outlier.
+func (s *Snapshot) Max() float64 {
+	if s.n == 0 {
+		return math.NaN()
+	}
+	return s.bucketStats().max(len(s.counts), func(i int) uint64 { return s.counts[i] })
+}
+
+// Bucket is one bucket's count and the half-open value range [Low, High) it covers. The low outlier
+// bucket has Low == -Inf, and the high outlier bucket has High == +Inf.
+type Bucket struct {
+	Low, High float64
+	Count     uint64
+}
+
+// Buckets returns the snapshot's per-bucket counts and the value range each one covers, in ascending
+// order, including the two outlier buckets. This is the low-level view behind Percentiles/Sum/etc.,
+// useful for exporters (e.g. Prometheus) that want to report the raw histogram rather than pre-computed
+// summary statistics.
+func (s *Snapshot) Buckets() []Bucket {
+	return s.bucketStats().buckets(s.counts)
+}
+
+func (s *Snapshot) bucketStats() bucketStats {
+	return bucketStats{shift: s.shift, scale: s.scale, low: s.low, high: s.high}
+}
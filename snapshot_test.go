@@ -0,0 +1,29 @@
+package loghistogram
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestSnapshotConcurrentPercentiles exercises the advice in Snapshot's doc comment -- take one Snapshot
+// and query it repeatedly -- from multiple goroutines at once. Run with -race to catch regressions in
+// middleBucketPercentileBits's atomic access.
+func TestSnapshotConcurrentPercentiles(t *testing.T) {
+	h := New(0, 1000, 1000)
+	for i := 0; i < 1000; i++ {
+		h.Accumulate(float64(i))
+	}
+	s := h.Snapshot()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				s.Percentiles(10, 50, 90, 99)
+			}
+		}()
+	}
+	wg.Wait()
+}
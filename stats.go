@@ -0,0 +1,99 @@
+/*
+  bucketStats is the bucket-midpoint math shared by Snapshot and WindowedHistogram's Sum/Variance/
+  Min/Max/Buckets. Both types keep their own bucket counts (a plain copy, or a ring summed on the fly),
+  but the arithmetic that turns those counts into values is identical, so it lives here once.
+
+  Copyright 2017 Nicolas Dade
+*/
+
+package loghistogram
+
+import "math"
+
+// bucketStats is the (shift, scale, low, high) a Histogram was constructed with, which is all the
+// methods below need to turn a []uint64 of bucket counts into values.
+type bucketStats struct {
+	shift, scale float64
+	low, high    float64
+}
+
+func (bs bucketStats) bucketToValue(bucket int) float64 {
+	return math.Exp(float64(bucket)/bs.scale) + bs.shift
+}
+
+// sum returns the approximate sum of counts (outliers excluded), using each bucket's midpoint as a
+// stand-in for the values that landed in it.
+func (bs bucketStats) sum(counts []uint64) float64 {
+	sum := 0.0
+	for i := 1; i < len(counts)-1; i++ {
+		if counts[i] == 0 {
+			continue
+		}
+		mid := (bs.bucketToValue(i-1) + bs.bucketToValue(i)) / 2
+		sum += mid * float64(counts[i])
+	}
+	return sum
+}
+
+// variance returns the approximate variance of counts (outliers excluded), given n (the total sample
+// count, including outliers, which are subtracted back out of it), using the standard one-pass
+// sum/sum-of-squares approach over bucket midpoints.
+func (bs bucketStats) variance(counts []uint64, n uint64) float64 {
+	sum, sumSq := 0.0, 0.0
+	for i := 1; i < len(counts)-1; i++ {
+		if counts[i] == 0 {
+			continue
+		}
+		mid := (bs.bucketToValue(i-1) + bs.bucketToValue(i)) / 2
+		cf := float64(counts[i])
+		sum += mid * cf
+		sumSq += mid * mid * cf
+	}
+	nf := float64(n - counts[0] - counts[len(counts)-1])
+	mean := sum / nf
+	return sumSq/nf - mean*mean
+}
+
+// min returns the lower bound of the lowest non-empty, non-outlier bucket, or NaN if there is none.
+// numBuckets is the total bucket count (including the two outlier buckets); count(i) must return bucket
+// i's count. Scanning via a callback rather than a materialized []uint64, unlike sum/variance/buckets,
+// lets WindowedHistogram stop at the first non-empty bucket instead of paying bucketTotal's ring-sum cost
+// for every bucket.
+func (bs bucketStats) min(numBuckets int, count func(int) uint64) float64 {
+	for i := 1; i < numBuckets-1; i++ {
+		if count(i) != 0 {
+			return bs.bucketToValue(i - 1)
+		}
+	}
+	return math.NaN()
+}
+
+// max is min's mirror image, scanning from the top down.
+func (bs bucketStats) max(numBuckets int, count func(int) uint64) float64 {
+	for i := numBuckets - 2; i >= 1; i-- {
+		if count(i) != 0 {
+			return bs.bucketToValue(i)
+		}
+	}
+	return math.NaN()
+}
+
+// buckets returns counts and the value range each one covers, in ascending order, including the two
+// outlier buckets.
+func (bs bucketStats) buckets(counts []uint64) []Bucket {
+	buckets := make([]Bucket, len(counts))
+	buckets[0] = Bucket{Low: math.Inf(-1), High: bs.low, Count: counts[0]}
+	buckets[len(buckets)-1] = Bucket{Low: bs.high, High: math.Inf(1), Count: counts[len(counts)-1]}
+	for i := 1; i < len(counts)-1; i++ {
+		lo := bs.bucketToValue(i - 1)
+		hi := bs.bucketToValue(i)
+		if i == 1 {
+			lo = bs.low
+		}
+		if i == len(counts)-2 {
+			hi = bs.high
+		}
+		buckets[i] = Bucket{Low: lo, High: hi, Count: counts[i]}
+	}
+	return buckets
+}
@@ -1,61 +1,185 @@
 /*
   windowed log-scaled histogram.
 
-  The windowing is simple. We rotate the histogram every
-  1/2 window, keeping the previous histogram around.
-  Statistics are calculated by summing across both
-  the current and the previous windows. For histograms
-  this is fine as long as a statistically sufficient number
-  of events happen in 1/2 a window period.
+  The windowing is done with a ring of N sub-histograms. Window()
+  advances the ring, retiring the oldest completed sub-window and
+  starting a fresh, empty one in its place. Statistics are calculated
+  by summing across all N-1 completed sub-windows plus the in-progress
+  one, giving a true sliding window over N-1 rotation intervals' worth
+  of history, rather than the 50%-jitter approximation a 2-slot
+  current/previous scheme gives.
 
   Copyright 2017 Nicolas Dade
 */
 
 package loghistogram
 
-import "math"
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type windowedSlot struct { // a single completed sub-window's data
+	n      uint64
+	counts []uint64
+}
 
 type WindowedHistogram struct {
-	Histogram
+	Histogram // the in-progress sub-window
 
-	prev struct { // previous window's data
-		n      uint64
-		counts []uint64
-	}
+	// lock protects the embedded Histogram's counts/n and windows/head across Window() rotations.
+	// Accumulate takes it for reading (RLock), so any number of Accumulate calls can still proceed
+	// concurrently with each other -- counts[i] and n are still updated with the same lock-free atomics
+	// as a plain Histogram -- but Window takes it for writing (Lock), so a rotation can never swap the
+	// counts slice or reset n while an Accumulate is in the middle of touching them.
+	lock sync.RWMutex
+
+	windows []windowedSlot // ring of the subWindows-1 completed sub-windows
+	head    int            // index into windows of the most recently completed sub-window
+
+	ticker *time.Ticker
+	stop   chan struct{}
 }
 
+// Accumulate adds a sample with value x to the in-progress sub-window. It shadows the embedded
+// Histogram's Accumulate to hold h.lock for reading while doing so, which is what makes it safe to call
+// concurrently with Window (see the lock field's doc comment).
+func (h *WindowedHistogram) Accumulate(x float64) {
+	h.lock.RLock()
+	h.Histogram.Accumulate(x)
+	h.lock.RUnlock()
+}
+
+// NewWindowed constructs a windowed histogram with the classic current+previous pair of sub-windows.
+// It is sugar for NewWindowedN(low, high, num_buckets, 2).
 func NewWindowed(low, high float64, num_buckets int) *WindowedHistogram {
+	return NewWindowedN(low, high, num_buckets, 2)
+}
+
+// NewWindowedN constructs a windowed histogram backed by a ring of subWindows sub-histograms. Window()
+// advances the ring by one slot; Count, Outliers, Percentiles and the statistical methods all sum
+// across the subWindows-1 completed sub-windows plus the in-progress one. A larger subWindows gives
+// finer-grained, less jittery sliding-window statistics at the cost of subWindows times the memory.
+func NewWindowedN(low, high float64, num_buckets, subWindows int) *WindowedHistogram {
+	if subWindows < 2 {
+		panic(fmt.Sprintf("loghistogram.NewWindowedN(%v, %v, %v, %v): subWindows must be >= 2", low, high, num_buckets, subWindows))
+	}
+
 	h := &WindowedHistogram{}
 	h.Histogram.init(low, high, num_buckets)
-	h.prev.counts = make([]uint64, len(h.counts)) // make a dummy, all-zeroed prev array so we don't have to think when rotating
+	h.windows = make([]windowedSlot, subWindows-1)
+	for i := range h.windows {
+		h.windows[i].counts = make([]uint64, len(h.counts))
+	}
 
 	return h
 }
 
+// Window advances the ring by one slot: the in-progress sub-window (the embedded Histogram) becomes the
+// most recently completed one, the oldest completed sub-window is retired and its buffer is reused,
+// zeroed, as the new in-progress sub-window.
 func (h *WindowedHistogram) Window() {
 	h.lock.Lock()
 
-	// zero the previous array. we reuse it to avoid creating garbage
-	for i := range h.prev.counts {
-		h.prev.counts[i] = 0
+	next := h.head + 1
+	if next >= len(h.windows) {
+		next = 0
+	}
+
+	// zero the slot we're about to retire into the new in-progress window. we reuse its array to avoid creating garbage
+	for i := range h.windows[next].counts {
+		h.windows[next].counts[i] = 0
 	}
-	h.prev.counts, h.counts = h.counts, h.prev.counts
-	h.prev.n, h.n = h.n, 0
+	h.windows[next].counts, h.counts = h.counts, h.windows[next].counts
+	h.windows[next].n, h.n = h.n, 0
+	h.head = next
 
 	h.lock.Unlock()
 }
 
+// Rotate calls Window(). now is unused; it exists so Rotate can be used wherever a func(time.Time) is
+// wanted, such as StartRotating's loop, which passes it each tick's time.
+func (h *WindowedHistogram) Rotate(now time.Time) {
+	h.Window()
+}
+
+// StartRotating spawns a goroutine that calls Window() every interval, giving a true sliding window
+// over (subWindows-1)*interval of history without the caller having to drive Window() themselves. Call
+// StopRotating to stop it.
+func (h *WindowedHistogram) StartRotating(interval time.Duration) {
+	h.ticker = time.NewTicker(interval)
+	h.stop = make(chan struct{})
+	ticker, stop := h.ticker, h.stop
+	go func() {
+		for {
+			select {
+			case t := <-ticker.C:
+				h.Rotate(t)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopRotating stops the goroutine started by StartRotating, if any.
+func (h *WindowedHistogram) StopRotating() {
+	if h.ticker == nil {
+		return
+	}
+	h.ticker.Stop()
+	close(h.stop)
+	h.ticker = nil
+	h.stop = nil
+}
+
+// bucketTotal returns the sum of bucket i's count across the in-progress sub-window and all completed
+// ones. Callers must hold h.lock.
+func (h *WindowedHistogram) bucketTotal(i int) uint64 {
+	c := h.counts[i]
+	for k := range h.windows {
+		c += h.windows[k].counts[i]
+	}
+	return c
+}
+
+// totalN returns h.n plus every completed sub-window's n. Callers must hold h.lock.
+func (h *WindowedHistogram) totalN() uint64 {
+	n := h.n
+	for k := range h.windows {
+		n += h.windows[k].n
+	}
+	return n
+}
+
+// totals returns bucketTotal(i) for every bucket i, including the two outlier buckets. Callers must
+// hold h.lock. This is what lets Sum/Variance/Buckets share their bucket-midpoint math with Snapshot via
+// bucketStats, rather than each re-summing the ring per bucket themselves.
+func (h *WindowedHistogram) totals() []uint64 {
+	totals := make([]uint64, len(h.counts))
+	for i := range totals {
+		totals[i] = h.bucketTotal(i)
+	}
+	return totals
+}
+
+func (h *WindowedHistogram) bucketStats() bucketStats {
+	return bucketStats{shift: h.shift, scale: h.scale, low: h.low, high: h.high}
+}
+
 func (h *WindowedHistogram) Count() uint64 {
 	h.lock.Lock()
-	n := h.n + h.prev.n
+	n := h.totalN()
 	h.lock.Unlock()
 	return n
 }
 
 func (h *WindowedHistogram) Outliers() (uint64, uint64) {
 	h.lock.Lock()
-	lo := h.counts[0] + h.prev.counts[0]
-	hi := h.counts[len(h.counts)-1] + h.prev.counts[len(h.counts)-1]
+	lo := h.bucketTotal(0)
+	hi := h.bucketTotal(len(h.counts) - 1)
 	h.lock.Unlock()
 	return lo, hi
 }
@@ -70,7 +194,7 @@ func (h *WindowedHistogram) Percentiles(pers ...float64) []float64 {
 	h.lock.Lock()
 	middle_bucket := len(h.counts) / 2
 	if h.middle_bucket_percentile >= 0 && pers[0] > h.middle_bucket_percentile {
-		n := h.n + h.prev.n
+		n := h.totalN()
 		a := n
 		if n == 0 {
 			goto return_nans
@@ -84,29 +208,40 @@ func (h *WindowedHistogram) Percentiles(pers ...float64) []float64 {
 				if i == middle_bucket {
 					h.middle_bucket_percentile = 100 * float64(a) / float64(n)
 				}
-				a -= h.counts[i] + h.prev.counts[i]
+				a -= h.bucketTotal(i)
 				i--
 			}
-			values[j] = h.bucketToValue(i)
+			ib := i + 1
+			before, after := a, a
+			if ib < len(h.counts) {
+				after = a + h.bucketTotal(ib)
+			}
+			values[j] = h.interpolate(ib, before, after, pn)
 		}
 	} else {
 		a := uint64(0)
-		n := h.n + h.prev.n
+		n := h.totalN()
 		if n == 0 {
 			goto return_nans
 		}
 		nf := float64(n)
 		i := 0
+		before := uint64(0)
 		for j, p := range pers {
 			pn := uint64(p * nf / 100)
 			for a < pn && i < len(h.counts) {
-				a += h.counts[i] + h.prev.counts[i]
+				before = a
+				a += h.bucketTotal(i)
 				if i == middle_bucket {
 					h.middle_bucket_percentile = 100 * float64(a) / float64(n)
 				}
 				i++
 			}
-			values[j] = h.bucketToValue(i)
+			ib := i - 1
+			if ib < 0 {
+				ib = 0
+			}
+			values[j] = h.interpolate(ib, before, a, pn)
 		}
 	}
 
@@ -125,3 +260,88 @@ return_nans:
 func (h *WindowedHistogram) Percentile(per float64) float64 {
 	return h.Percentiles(per)[0]
 }
+
+// Sum returns the approximate sum of all accumulated samples across every sub-window (outliers
+// excluded). NaN is returned if Count is 0.
+func (h *WindowedHistogram) Sum() float64 {
+	h.lock.Lock()
+	n := h.totalN()
+	if n == 0 {
+		h.lock.Unlock()
+		return math.NaN()
+	}
+	counts, bs := h.totals(), h.bucketStats()
+	h.lock.Unlock()
+	return bs.sum(counts)
+}
+
+// Mean returns the approximate mean of all accumulated samples across every sub-window. NaN is returned
+// if Count is 0.
+func (h *WindowedHistogram) Mean() float64 {
+	h.lock.Lock()
+	n := h.totalN()
+	inRange := n - h.bucketTotal(0) - h.bucketTotal(len(h.counts)-1)
+	h.lock.Unlock()
+	if n == 0 {
+		return math.NaN()
+	}
+	return h.Sum() / float64(inRange)
+}
+
+// Variance returns the approximate variance of all accumulated samples across every sub-window, using
+// the standard one-pass sum/sum-of-squares approach over bucket midpoints. NaN is returned if Count is
+// 0.
+func (h *WindowedHistogram) Variance() float64 {
+	h.lock.Lock()
+	n := h.totalN()
+	if n == 0 {
+		h.lock.Unlock()
+		return math.NaN()
+	}
+	counts, bs := h.totals(), h.bucketStats()
+	h.lock.Unlock()
+	return bs.variance(counts, n)
+}
+
+// StdDev returns the approximate standard deviation of all accumulated samples across every sub-window.
+// NaN is returned if Count is 0.
+func (h *WindowedHistogram) StdDev() float64 {
+	return math.Sqrt(h.Variance())
+}
+
+// Min returns the lower bound of the lowest non-empty, non-outlier bucket across every sub-window. NaN
+// is returned if Count is 0, or if every accumulated sample is an outlier.
+func (h *WindowedHistogram) Min() float64 {
+	h.lock.Lock()
+	n := h.totalN()
+	if n == 0 {
+		h.lock.Unlock()
+		return math.NaN()
+	}
+	v := h.bucketStats().min(len(h.counts), h.bucketTotal)
+	h.lock.Unlock()
+	return v
+}
+
+// Max returns the upper bound of the highest non-empty, non-outlier bucket across every sub-window. NaN
+// is returned if Count is 0, or if every accumulated sample is an outlier.
+func (h *WindowedHistogram) Max() float64 {
+	h.lock.Lock()
+	n := h.totalN()
+	if n == 0 {
+		h.lock.Unlock()
+		return math.NaN()
+	}
+	v := h.bucketStats().max(len(h.counts), h.bucketTotal)
+	h.lock.Unlock()
+	return v
+}
+
+// Buckets returns the per-bucket counts, summed across every sub-window, and the value range each one
+// covers, including the two outlier buckets. See Snapshot.Buckets.
+func (h *WindowedHistogram) Buckets() []Bucket {
+	h.lock.Lock()
+	counts, bs := h.totals(), h.bucketStats()
+	h.lock.Unlock()
+	return bs.buckets(counts)
+}
@@ -3,6 +3,7 @@ package loghistogram
 import (
 	"math"
 	"testing"
+	"time"
 )
 
 func TestWindowedAccumulate(t *testing.T) {
@@ -43,6 +44,93 @@ func TestWindowedOutliers(t *testing.T) {
 	}
 }
 
+func TestWindowedStatistics(t *testing.T) {
+	h := NewWindowed(0, 1000, 1000)
+	for i := 0; i < 10; i++ {
+		h.Accumulate(300)
+	}
+	h.Window() // move the samples into a completed sub-window, to exercise bucketTotal's summing too
+	for i := 0; i < 10; i++ {
+		h.Accumulate(600)
+	}
+
+	if sum := h.Sum(); math.Abs(sum-9000) > 100 {
+		t.Errorf("h.Sum() = %v, want ~9000", sum)
+	}
+	if lo, hi := h.Min(), h.Max(); !(lo <= 300 && 600 <= hi) {
+		t.Errorf("h.Min(), h.Max() = %v, %v; want a range containing [300, 600]", lo, hi)
+	}
+	if p := h.Percentile(50); math.Abs(p-300) > 30 {
+		t.Errorf("h.Percentile(50) = %v, want ~300", p)
+	}
+}
+
+// TestWindowedConcurrentAccumulateAndWindow hammers Accumulate and Window from separate goroutines at
+// once. It doesn't check any resulting values -- Window's swap makes exactly which samples land in
+// which sub-window inherently racy from the caller's point of view -- it exists to be run with -race
+// and catch regressions in the synchronization between the two (parallel in spirit to
+// TestSnapshotConcurrentPercentiles).
+func TestWindowedConcurrentAccumulateAndWindow(t *testing.T) {
+	h := NewWindowed(0, 1000, 1000)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			h.Accumulate(float64(i % 1000))
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		h.Window()
+	}
+	<-done
+}
+
+// TestWindowedNRingEviction exercises a ring with more than the historical 2 sub-windows, checking that
+// a sample survives exactly subWindows-1 rotations before falling out of the window.
+func TestWindowedNRingEviction(t *testing.T) {
+	h := NewWindowedN(0, 1000, 1000, 4) // in-progress + 3 completed sub-windows of history
+
+	h.Accumulate(100)
+	for i := 0; i < 3; i++ {
+		h.Window()
+		if h.Count() != 1 {
+			t.Fatalf("after rotation %d, Count() = %v, want 1 (the sample should still be in the window)", i+1, h.Count())
+		}
+	}
+	h.Window() // the 4th rotation retires the sub-window the sample landed in
+	if h.Count() != 0 {
+		t.Errorf("after the 4th rotation, Count() = %v, want 0 (the sample should have fallen out of the ring)", h.Count())
+	}
+}
+
+// TestWindowedStartStopRotating checks that StartRotating's ticker goroutine actually rotates the ring,
+// and that StopRotating actually stops it.
+func TestWindowedStartStopRotating(t *testing.T) {
+	h := NewWindowedN(0, 1000, 10, 100) // a ring long enough that it won't wrap back to head==0 below
+
+	h.StartRotating(5 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	h.StopRotating()
+	time.Sleep(10 * time.Millisecond) // let a tick already in flight when StopRotating was called land
+
+	h.lock.Lock()
+	headAfterStop := h.head
+	h.lock.Unlock()
+	if headAfterStop == 0 {
+		t.Fatal("StartRotating doesn't appear to have rotated the ring at all")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	h.lock.Lock()
+	headLater := h.head
+	h.lock.Unlock()
+	if headLater != headAfterStop {
+		t.Errorf("h.head changed from %v to %v after StopRotating, ticker goroutine is still running", headAfterStop, headLater)
+	}
+}
+
 func TestWindowedEmptyHistogram(t *testing.T) {
 	h := NewWindowed(0, 1, 10)
 	p := h.Percentile(50)